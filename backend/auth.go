@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Claims is the JWT payload issued by /login and verified on every upgrade.
+// Rooms lets a token scope a user to a subset of channels; an empty slice
+// means no restriction.
+type Claims struct {
+	UserID string   `json:"user_id"`
+	Rooms  []string `json:"rooms,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// issueToken signs a short-lived token for username/userID using cfg's key.
+func issueToken(cfg Config, username, userID string, rooms []string) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		UserID: userID,
+		Rooms:  rooms,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   username,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(cfg.JWTTokenTTL)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(cfg.JWTSigningKey)
+}
+
+// authenticate extracts and verifies the bearer token from the
+// Authorization header or the token query parameter, returning the parsed
+// claims on success.
+func authenticate(cfg Config, r *http.Request) (*Claims, error) {
+	raw := bearerToken(r)
+	if raw == "" {
+		return nil, jwt.ErrTokenMalformed
+	}
+
+	token, err := jwt.ParseWithClaims(raw, &Claims{}, func(t *jwt.Token) (interface{}, error) {
+		return cfg.JWTSigningKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}))
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return claims, nil
+}
+
+// bearerToken reads the token from "Authorization: Bearer <token>" or, when
+// that header isn't set (browsers can't set custom headers on the WebSocket
+// handshake), the ?token= query parameter.
+func bearerToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if rest, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			return rest
+		}
+	}
+	return r.URL.Query().Get("token")
+}
+
+// loginRequest is the body accepted by handleLogin.
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// handleLogin verifies the submitted credentials against auth and, on
+// success, issues a short-lived JWT scoped to the userID and rooms auth
+// reports for that username — never to whatever the client asserts, so a
+// caller can't mint a token for someone else's identity or room list.
+func handleLogin(cfg Config, auth Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req loginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Username == "" || req.Password == "" {
+			http.Error(w, "username and password are required", http.StatusBadRequest)
+			return
+		}
+
+		userID, rooms, err := auth.Authenticate(req.Username, req.Password)
+		if err != nil {
+			http.Error(w, "invalid username or password", http.StatusUnauthorized)
+			return
+		}
+
+		token, err := issueToken(cfg, req.Username, userID, rooms)
+		if err != nil {
+			http.Error(w, "failed to issue token", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"token":      token,
+			"expires_at": time.Now().Add(cfg.JWTTokenTTL).Format(time.RFC3339),
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testAuthConfig() Config {
+	cfg := DefaultConfig()
+	cfg.JWTSigningKey = []byte("test-signing-key")
+	return cfg
+}
+
+func TestAuthenticateRoundTrip(t *testing.T) {
+	cfg := testAuthConfig()
+	token, err := issueToken(cfg, "alice", "u1", []string{"room-a"})
+	if err != nil {
+		t.Fatalf("issueToken: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	claims, err := authenticate(cfg, req)
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if claims.Subject != "alice" || claims.UserID != "u1" {
+		t.Fatalf("claims = %+v, want subject alice, user_id u1", claims)
+	}
+}
+
+func TestAuthenticateViaQueryParam(t *testing.T) {
+	// Browsers can't set custom headers on the WebSocket handshake, so a
+	// ?token= query param must work too.
+	cfg := testAuthConfig()
+	token, err := issueToken(cfg, "alice", "u1", nil)
+	if err != nil {
+		t.Fatalf("issueToken: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/ws?token="+token, nil)
+	if _, err := authenticate(cfg, req); err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+}
+
+func TestAuthenticateRejectsBadSignature(t *testing.T) {
+	cfg := testAuthConfig()
+	token, err := issueToken(cfg, "alice", "u1", nil)
+	if err != nil {
+		t.Fatalf("issueToken: %v", err)
+	}
+
+	other := testAuthConfig()
+	other.JWTSigningKey = []byte("a-different-key")
+
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	if _, err := authenticate(other, req); err == nil {
+		t.Fatal("authenticate: want error for a token signed with a different key, got nil")
+	}
+}
+
+func TestAuthenticateRejectsExpiredToken(t *testing.T) {
+	cfg := testAuthConfig()
+	cfg.JWTTokenTTL = -time.Minute // already expired the instant it's issued
+
+	token, err := issueToken(cfg, "alice", "u1", nil)
+	if err != nil {
+		t.Fatalf("issueToken: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	if _, err := authenticate(cfg, req); err == nil {
+		t.Fatal("authenticate: want error for an expired token, got nil")
+	}
+}
+
+func TestAuthenticateRejectsMissingToken(t *testing.T) {
+	cfg := testAuthConfig()
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+
+	if _, err := authenticate(cfg, req); err == nil {
+		t.Fatal("authenticate: want error for a request with no token, got nil")
+	}
+}
+
+func TestStaticAuthenticatorRejectsUnknownUserAndBadPassword(t *testing.T) {
+	auth := NewStaticAuthenticator(parseStaticUsers("alice:secret:room-a"))
+
+	if _, _, err := auth.Authenticate("alice", "wrong"); err != ErrInvalidCredentials {
+		t.Fatalf("Authenticate(bad password) err = %v, want ErrInvalidCredentials", err)
+	}
+	if _, _, err := auth.Authenticate("bob", "secret"); err != ErrInvalidCredentials {
+		t.Fatalf("Authenticate(unknown user) err = %v, want ErrInvalidCredentials", err)
+	}
+
+	userID, rooms, err := auth.Authenticate("alice", "secret")
+	if err != nil {
+		t.Fatalf("Authenticate(correct credentials): %v", err)
+	}
+	if userID != "alice" || len(rooms) != 1 || rooms[0] != "room-a" {
+		t.Fatalf("Authenticate(correct credentials) = (%q, %v), want (alice, [room-a])", userID, rooms)
+	}
+}
+
+func TestRoomAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		channel string
+		want    bool
+	}{
+		{"unrestricted token allows anything", nil, "room-a", true},
+		{"scoped token allows its own room", []string{"room-a"}, "room-a", true},
+		{"scoped token rejects other rooms", []string{"room-a"}, "room-b", false},
+		{"scoped token always allows the broadcast channel", []string{"room-a"}, BroadcastChannel, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{allowedRooms: tt.allowed}
+			if got := c.roomAllowed(tt.channel); got != tt.want {
+				t.Errorf("roomAllowed(%q) = %v, want %v", tt.channel, got, tt.want)
+			}
+		})
+	}
+}
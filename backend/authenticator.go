@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidCredentials is returned by Authenticator.Authenticate when the
+// username is unknown or the password doesn't match.
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// Authenticator verifies a username/password pair and reports the identity
+// /login should mint a token for. It exists so handleLogin never has to
+// trust client-asserted identity: userID and rooms come from here, not from
+// the request body.
+type Authenticator interface {
+	Authenticate(username, password string) (userID string, rooms []string, err error)
+}
+
+// newAuthenticator builds the Authenticator configured by cfg.AuthDriver.
+func newAuthenticator(cfg Config) (Authenticator, error) {
+	switch cfg.AuthDriver {
+	case "", "static":
+		return NewStaticAuthenticator(cfg.AuthUsers), nil
+	default:
+		return nil, fmt.Errorf("unknown auth driver %q", cfg.AuthDriver)
+	}
+}
+
+// staticCredential is one entry of a StaticAuthenticator's user table.
+type staticCredential struct {
+	userID   string
+	password string
+	rooms    []string
+}
+
+// StaticAuthenticator checks credentials against a fixed, in-process user
+// table loaded from config at startup. It exists as the zero-configuration
+// default and as an Authenticator implementation to test against; swapping
+// in a database- or SSO-backed Authenticator for production is a matter of
+// implementing the interface and changing cfg.AuthDriver.
+type StaticAuthenticator struct {
+	users map[string]staticCredential
+}
+
+// NewStaticAuthenticator builds a StaticAuthenticator from users.
+func NewStaticAuthenticator(users map[string]staticCredential) *StaticAuthenticator {
+	return &StaticAuthenticator{users: users}
+}
+
+// Authenticate implements Authenticator.
+func (a *StaticAuthenticator) Authenticate(username, password string) (string, []string, error) {
+	cred, ok := a.users[username]
+	if !ok {
+		return "", nil, ErrInvalidCredentials
+	}
+	// subtle.ConstantTimeCompare to avoid leaking how much of the password
+	// matches via response timing; it returns early on a length mismatch, so
+	// the length itself is not hidden.
+	if subtle.ConstantTimeCompare([]byte(cred.password), []byte(password)) != 1 {
+		return "", nil, ErrInvalidCredentials
+	}
+	userID := cred.userID
+	if userID == "" {
+		userID = username
+	}
+	return userID, cred.rooms, nil
+}
+
+// parseStaticUsers parses the AUTH_USERS env var into a StaticAuthenticator
+// user table. Format: "user:password:room1|room2,user2:password2" — rooms
+// are pipe-separated and optional; an omitted room list leaves the user
+// unrestricted.
+func parseStaticUsers(v string) map[string]staticCredential {
+	users := make(map[string]staticCredential)
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) < 2 {
+			continue
+		}
+		username, password := parts[0], parts[1]
+		var rooms []string
+		if len(parts) == 3 && parts[2] != "" {
+			rooms = strings.Split(parts[2], "|")
+		}
+		users[username] = staticCredential{userID: username, password: password, rooms: rooms}
+	}
+	return users
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the tunable knobs for the WebSocket server. All fields have
+// sane defaults so the server can still be started with zero configuration;
+// set the corresponding env var to override a default without recompiling.
+type Config struct {
+	HandshakeTimeout  time.Duration
+	ReadBufferSize    int
+	WriteBufferSize   int
+	EnableCompression bool
+	MaxMessageSize    int64
+	AllowedOrigins    []string // empty means "allow any origin"
+	PingInterval      time.Duration
+	PongWait          time.Duration
+	WriteWait         time.Duration
+	Subprotocols      []string
+	JWTSigningKey     []byte                      // HMAC key used to sign and verify session tokens
+	JWTTokenTTL       time.Duration               // lifetime of tokens issued by /login
+	StoreDriver       string                      // "memory" (default), "sqlite", or "postgres"
+	StoreDSN          string                      // SQLite file path or Postgres connection string; unused for "memory"
+	AuthDriver        string                      // "static" (default); only one implementation exists today
+	AuthUsers         map[string]staticCredential // credential table for the "static" auth driver, keyed by username
+}
+
+// DefaultConfig returns the configuration used when no env var overrides a
+// field. Buffer sizes and ping/pong/write timings keep the values the server
+// used to have hardcoded.
+func DefaultConfig() Config {
+	return Config{
+		HandshakeTimeout:  10 * time.Second,
+		ReadBufferSize:    1024,
+		WriteBufferSize:   1024,
+		EnableCompression: true,
+		MaxMessageSize:    512 * 1024,
+		AllowedOrigins:    nil,
+		PingInterval:      54 * time.Second,
+		PongWait:          60 * time.Second,
+		WriteWait:         10 * time.Second,
+		Subprotocols:      nil,
+		JWTSigningKey:     []byte("dev-only-insecure-signing-key"),
+		JWTTokenTTL:       15 * time.Minute,
+		StoreDriver:       "memory",
+		AuthDriver:        "static",
+	}
+}
+
+// LoadConfig builds a Config from environment variables, falling back to
+// DefaultConfig for anything unset or unparsable.
+func LoadConfig() Config {
+	cfg := DefaultConfig()
+
+	if v := os.Getenv("WS_HANDSHAKE_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.HandshakeTimeout = d
+		}
+	}
+	if v := os.Getenv("WS_READ_BUFFER_SIZE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.ReadBufferSize = n
+		}
+	}
+	if v := os.Getenv("WS_WRITE_BUFFER_SIZE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.WriteBufferSize = n
+		}
+	}
+	if v := os.Getenv("WS_ENABLE_COMPRESSION"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.EnableCompression = b
+		}
+	}
+	if v := os.Getenv("WS_MAX_MESSAGE_SIZE"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			cfg.MaxMessageSize = n
+		}
+	}
+	if v := os.Getenv("WS_ALLOWED_ORIGINS"); v != "" {
+		cfg.AllowedOrigins = splitAndTrim(v)
+	}
+	if v := os.Getenv("WS_PING_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.PingInterval = d
+		}
+	}
+	if v := os.Getenv("WS_PONG_WAIT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.PongWait = d
+		}
+	}
+	if v := os.Getenv("WS_WRITE_WAIT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.WriteWait = d
+		}
+	}
+	if v := os.Getenv("WS_SUBPROTOCOLS"); v != "" {
+		cfg.Subprotocols = splitAndTrim(v)
+	}
+	if v := os.Getenv("JWT_SIGNING_KEY"); v != "" {
+		cfg.JWTSigningKey = []byte(v)
+	}
+	if v := os.Getenv("JWT_TOKEN_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.JWTTokenTTL = d
+		}
+	}
+	if v := os.Getenv("STORE_DRIVER"); v != "" {
+		cfg.StoreDriver = v
+	}
+	if v := os.Getenv("STORE_DSN"); v != "" {
+		cfg.StoreDSN = v
+	}
+	if v := os.Getenv("AUTH_DRIVER"); v != "" {
+		cfg.AuthDriver = v
+	}
+	if v := os.Getenv("AUTH_USERS"); v != "" {
+		cfg.AuthUsers = parseStaticUsers(v)
+	}
+
+	return cfg
+}
+
+// originAllowed reports whether origin is permitted to upgrade. An empty
+// allowlist means any origin is allowed, preserving the permissive default
+// used during development.
+func (c Config) originAllowed(origin string) bool {
+	if len(c.AllowedOrigins) == 0 {
+		return true
+	}
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+func splitAndTrim(v string) []string {
+	parts := strings.Split(v, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
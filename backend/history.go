@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// claimsContextKey is the context key authMiddleware attaches the request's
+// parsed Claims under, so downstream handlers can enforce per-token scoping
+// (see handleRoomHistory) instead of only checking the token is well-formed.
+type claimsContextKey struct{}
+
+// authMiddleware rejects the request with 401 unless it carries a valid
+// session token, the same check handleWebSocket performs before upgrading.
+func authMiddleware(cfg Config, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := authenticate(cfg, r)
+		if err != nil {
+			http.Error(w, "invalid or expired token", http.StatusUnauthorized)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey{}, claims)))
+	}
+}
+
+// defaultHistoryLimit caps a history request when the caller omits ?limit=.
+const defaultHistoryLimit = 50
+
+// handleRoomHistory serves GET /rooms/{room}/history?before=&limit= from store.
+func handleRoomHistory(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		room := r.PathValue("room")
+
+		if claims, ok := r.Context().Value(claimsContextKey{}).(*Claims); ok {
+			if len(claims.Rooms) > 0 && !slices.Contains(claims.Rooms, room) {
+				http.Error(w, "token is not scoped to this room", http.StatusForbidden)
+				return
+			}
+		}
+
+		var before time.Time
+		if v := r.URL.Query().Get("before"); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, "before must be an RFC3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			before = parsed
+		}
+
+		limit := defaultHistoryLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			parsed, err := strconv.Atoi(v)
+			if err != nil || parsed <= 0 {
+				http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			limit = parsed
+		}
+
+		messages, err := store.History(r.Context(), room, before, limit)
+		if err != nil {
+			http.Error(w, "failed to load history", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(messages)
+	}
+}
+
+// roomSummary describes one active room in the GET /rooms listing.
+type roomSummary struct {
+	Room        string `json:"room"`
+	Subscribers int    `json:"subscribers"`
+}
+
+// handleListRooms serves GET /rooms, listing every channel the hub currently
+// has subscribers for, excluding the internal broadcast wildcard.
+func handleListRooms(hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hub.mu.RLock()
+		rooms := make([]roomSummary, 0, len(hub.subscribers))
+		for channel, clients := range hub.subscribers {
+			if channel == BroadcastChannel {
+				continue
+			}
+			rooms = append(rooms, roomSummary{Room: channel, Subscribers: len(clients)})
+		}
+		hub.mu.RUnlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rooms)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestHandleRoomHistoryRejectsOutOfScopeRoom guards against a regression
+// where authMiddleware only checked that a token was well-formed and
+// handleRoomHistory served store.History for whatever room path segment was
+// requested — so a token scoped to a single room could read every other
+// room's persisted history via GET /rooms/{other-room}/history.
+func TestHandleRoomHistoryRejectsOutOfScopeRoom(t *testing.T) {
+	store := NewMemoryStore()
+	store.Append(context.Background(), Message{Chan: "room-a", Content: "hi"})
+	store.Append(context.Background(), Message{Chan: "room-b", Content: "secret"})
+
+	handler := handleRoomHistory(store)
+
+	withClaims := func(room string, claims *Claims) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/rooms/"+room+"/history", nil)
+		req.SetPathValue("room", room)
+		if claims != nil {
+			req = req.WithContext(context.WithValue(req.Context(), claimsContextKey{}, claims))
+		}
+		return req
+	}
+
+	tests := []struct {
+		name       string
+		req        *http.Request
+		wantStatus int
+	}{
+		{"scoped token reading its own room", withClaims("room-a", &Claims{Rooms: []string{"room-a"}}), http.StatusOK},
+		{"scoped token reading another room", withClaims("room-b", &Claims{Rooms: []string{"room-a"}}), http.StatusForbidden},
+		{"unrestricted token reads any room", withClaims("room-b", &Claims{}), http.StatusOK},
+		{"no claims in context (e.g. unmiddlewared call)", withClaims("room-b", nil), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler(rec, tt.req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body: %s)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func recordTestHistory(h *Hub, channel string, id uint64, ts time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if id > h.nextID {
+		h.nextID = id
+	}
+	h.recordHistory(channel, Message{ID: id, Chan: channel, Timestamp: ts})
+}
+
+func TestHubRecordHistoryTrimsToSize(t *testing.T) {
+	h := NewHubWithHistory(3)
+	base := time.Now()
+	for i := uint64(1); i <= 5; i++ {
+		recordTestHistory(h, "room", i, base.Add(time.Duration(i)*time.Second))
+	}
+
+	got := h.historySince("room", 0, time.Time{})
+	if len(got) != 3 {
+		t.Fatalf("len(got) = %d, want 3 (ring buffer capped at historySize)", len(got))
+	}
+	wantIDs := []uint64{3, 4, 5}
+	for i, msg := range got {
+		if msg.ID != wantIDs[i] {
+			t.Fatalf("got[%d].ID = %d, want %d (oldest evicted first)", i, msg.ID, wantIDs[i])
+		}
+	}
+}
+
+func TestHubHistorySinceFiltersByID(t *testing.T) {
+	h := NewHubWithHistory(10)
+	base := time.Now()
+	for i := uint64(1); i <= 5; i++ {
+		recordTestHistory(h, "room", i, base.Add(time.Duration(i)*time.Second))
+	}
+
+	got := h.historySince("room", 3, time.Time{})
+	if len(got) != 2 {
+		t.Fatalf("len(got) = %d, want 2 (ids 4 and 5)", len(got))
+	}
+	for _, msg := range got {
+		if msg.ID <= 3 {
+			t.Fatalf("historySince(since=3) returned id %d, want > 3", msg.ID)
+		}
+	}
+}
+
+func TestHubHistorySinceFiltersByCursor(t *testing.T) {
+	h := NewHubWithHistory(10)
+	base := time.Now()
+	for i := uint64(1); i <= 5; i++ {
+		recordTestHistory(h, "room", i, base.Add(time.Duration(i)*time.Second))
+	}
+
+	cursor := base.Add(3 * time.Second)
+	got := h.historySince("room", 0, cursor)
+	if len(got) != 2 {
+		t.Fatalf("len(got) = %d, want 2 (timestamps after cursor)", len(got))
+	}
+	for _, msg := range got {
+		if !msg.Timestamp.After(cursor) {
+			t.Fatalf("historySince(cursor) returned timestamp %v, want after %v", msg.Timestamp, cursor)
+		}
+	}
+}
+
+func TestHubHistorySinceUnknownChannel(t *testing.T) {
+	h := NewHubWithHistory(10)
+	if got := h.historySince("never-subscribed", 0, time.Time{}); got != nil {
+		t.Fatalf("historySince(unknown channel) = %v, want nil", got)
+	}
+}
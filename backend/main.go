@@ -1,48 +1,280 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
 )
 
+// Control message types used on the subscribe/unsubscribe/publish control plane.
+const (
+	ControlSubscribe   = "subscribe"
+	ControlUnsubscribe = "unsubscribe"
+	ControlPublish     = "publish"
+)
+
+// Signaling message types used to broker WebRTC peer connections. When a
+// client sends one, it is directed (see Message.Target) and bypasses the
+// channel broadcast fan-out; the peer-join/peer-leave announcements the
+// server itself makes on subscribe/unsubscribe go to the whole channel.
+const (
+	SignalSDPOffer     = "sdp-offer"
+	SignalSDPAnswer    = "sdp-answer"
+	SignalICECandidate = "ice-candidate"
+	SignalPeerJoin     = "peer-join"
+	SignalPeerLeave    = "peer-leave"
+)
+
+// SFUTarget is the reserved Message.Target value a client uses to address
+// its SDP offer to the server's SFU relay instead of to another peer.
+const SFUTarget = "__sfu__"
+
+// isSignaling reports whether t is one of the directed WebRTC signaling
+// message types rather than a regular channel broadcast.
+func isSignaling(t string) bool {
+	switch t {
+	case SignalSDPOffer, SignalSDPAnswer, SignalICECandidate, SignalPeerJoin, SignalPeerLeave:
+		return true
+	default:
+		return false
+	}
+}
+
+// BroadcastChannel is the wildcard channel every client is implicitly subscribed to.
+const BroadcastChannel = "#all"
+
 // Message represents a chat message structure
 type Message struct {
-	Type      string    `json:"type"`      // "join", "leave", "message"
-	Username  string    `json:"username"`  // Sender's username
-	Content   string    `json:"content"`   // Message content
-	Room      string    `json:"room"`      // Room identifier
-	Timestamp time.Time `json:"timestamp"` // Message timestamp
+	ID        uint64    `json:"id,omitempty"`     // Monotonic ID assigned when the message enters broadcast; used as a resume cursor
+	Type      string    `json:"type"`             // "join", "leave", "message", "subscribe", "unsubscribe", "publish", or a signaling type
+	Username  string    `json:"username"`         // Sender's display name
+	UserID    string    `json:"user_id"`          // Sender's stable identity, taken from the JWT user_id claim; survives display name collisions
+	Content   string    `json:"content"`          // Message content
+	Chan      string    `json:"chan"`             // Channel the message is routed to (e.g. a room name, or "#all")
+	Target    string    `json:"target,omitempty"` // Recipient username for directed signaling messages; ignored otherwise
+	Since     uint64    `json:"since,omitempty"`  // On a "subscribe" message, resume replay after this message ID
+	Cursor    time.Time `json:"cursor,omitempty"` // On a "subscribe" message, resume replay after this timestamp; applied together with Since when both are set
+	Timestamp time.Time `json:"timestamp"`        // Message timestamp
 }
 
 // Client represents a connected WebSocket client
 type Client struct {
-	conn     *websocket.Conn
-	username string
-	room     string
-	send     chan Message
+	id           string // opaque identifier used to correlate log lines for this connection
+	remoteAddr   string
+	conn         *websocket.Conn
+	username     string
+	userID       string
+	allowedRooms []string        // from the JWT claims; empty means unrestricted
+	channels     map[string]bool // channels this client currently subscribes to
+	send         chan Message
+	closed       bool // set once the hub has closed send; guarded by Hub.mu
+	cfg          Config
+	sfu          *SFU // nil unless the server was started with --sfu
+}
+
+// DefaultHistorySize is how many recent messages each channel's ring buffer
+// retains for replay to late joiners and reconnecting clients.
+const DefaultHistorySize = 200
+
+// nextClientID hands out opaque, monotonically increasing client_id values
+// for log correlation; it carries no other meaning.
+var nextClientID atomic.Uint64
+
+func newClientID() string {
+	return strconv.FormatUint(nextClientID.Add(1), 10)
 }
 
-// Hub maintains active clients and broadcasts messages
+// roomAllowed reports whether the client's token permits subscribing to
+// channel. An empty allowedRooms means the token carries no restriction;
+// the broadcast channel is always permitted.
+func (c *Client) roomAllowed(channel string) bool {
+	if len(c.allowedRooms) == 0 || channel == BroadcastChannel {
+		return true
+	}
+	for _, room := range c.allowedRooms {
+		if room == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// Hub maintains the subscription index and broadcasts messages to subscribers
 type Hub struct {
-	clients    map[*Client]bool
-	broadcast  chan Message
-	register   chan *Client
-	unregister chan *Client
-	mu         sync.RWMutex
+	// subscribers maps a channel name to the set of clients subscribed to it
+	subscribers map[string]map[*Client]bool
+	broadcast   chan Message
+	// direct carries signaling messages (see isSignaling) straight to
+	// Message.Target, bypassing the channel fan-out entirely.
+	direct chan Message
+	// history holds, per channel, a bounded ring buffer of the most recent
+	// broadcast messages so new subscribers can be replayed the backlog.
+	history     map[string][]Message
+	historySize int
+	nextID      uint64
+	register    chan *Client
+	unregister  chan *Client
+	mu          sync.RWMutex
+	// store, if non-nil, receives every broadcast message via persist so
+	// slow durable storage never blocks the fan-out.
+	store   Store
+	persist chan Message
 }
 
-// NewHub creates and initializes a new Hub
+// persistBufferSize bounds how far storage can lag behind the live fan-out;
+// once the buffer is full, Hub.Run drops (and logs) further messages instead
+// of persisting them, rather than blocking on a slow Store.
+const persistBufferSize = 1024
+
+// NewHub creates and initializes a new Hub with the default history size
+// and no persistence; broadcasts are never durable.
 func NewHub() *Hub {
+	return NewHubWithHistory(DefaultHistorySize)
+}
+
+// NewHubWithHistory creates a Hub whose per-channel ring buffer holds at
+// most historySize messages.
+func NewHubWithHistory(historySize int) *Hub {
 	return &Hub{
-		clients:    make(map[*Client]bool),
-		broadcast:  make(chan Message),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
+		subscribers: make(map[string]map[*Client]bool),
+		broadcast:   make(chan Message),
+		direct:      make(chan Message),
+		history:     make(map[string][]Message),
+		historySize: historySize,
+		register:    make(chan *Client),
+		unregister:  make(chan *Client),
+	}
+}
+
+// NewHubWithStore creates a Hub that additionally persists broadcast messages
+// to store, asynchronously, so storage latency never blocks clients. Messages
+// that arrive while the persist buffer is full are not persisted.
+func NewHubWithStore(historySize int, store Store) *Hub {
+	h := NewHubWithHistory(historySize)
+	h.store = store
+	h.persist = make(chan Message, persistBufferSize)
+	return h
+}
+
+// runPersistence drains h.persist into h.store until the channel is closed.
+// Call as its own goroutine; a no-op when the Hub has no store configured.
+func (h *Hub) runPersistence() {
+	for msg := range h.persist {
+		if err := h.store.Append(context.Background(), msg); err != nil {
+			logger.Error("failed to persist message", "room", msg.Chan, "error", err)
+		}
+	}
+}
+
+// recordHistory appends msg to channel's ring buffer, evicting the oldest
+// entry once historySize is exceeded. Caller must hold h.mu.
+func (h *Hub) recordHistory(channel string, msg Message) {
+	if h.historySize <= 0 {
+		return
+	}
+	buf := append(h.history[channel], msg)
+	if len(buf) > h.historySize {
+		buf = buf[len(buf)-h.historySize:]
+	}
+	h.history[channel] = buf
+}
+
+// historySince returns the buffered messages for channel with an ID greater
+// than since and, if cursor is non-zero, a Timestamp after cursor too.
+func (h *Hub) historySince(channel string, since uint64, cursor time.Time) []Message {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var replay []Message
+	for _, msg := range h.history[channel] {
+		if msg.ID <= since {
+			continue
+		}
+		if !cursor.IsZero() && !msg.Timestamp.After(cursor) {
+			continue
+		}
+		replay = append(replay, msg)
+	}
+	return replay
+}
+
+// Peers returns the usernames of clients currently subscribed to channel,
+// used to hand a new peer its call roster on join.
+func (h *Hub) Peers(channel string) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	peers := make([]string, 0, len(h.subscribers[channel]))
+	for client := range h.subscribers[channel] {
+		peers = append(peers, client.username)
+	}
+	return peers
+}
+
+// subscribe adds client to channel's subscriber set. Caller must hold h.mu.
+func (h *Hub) subscribe(client *Client, channel string) {
+	if h.subscribers[channel] == nil {
+		h.subscribers[channel] = make(map[*Client]bool)
+	}
+	h.subscribers[channel][client] = true
+	client.channels[channel] = true
+}
+
+// unsubscribe removes client from channel's subscriber set. Caller must hold h.mu.
+func (h *Hub) unsubscribe(client *Client, channel string) {
+	if clients, ok := h.subscribers[channel]; ok {
+		delete(clients, client)
+		if len(clients) == 0 {
+			delete(h.subscribers, channel)
+		}
+	}
+	delete(client.channels, channel)
+}
+
+// subscribed reports whether client is currently subscribed to channel.
+// client.channels is otherwise only ever read or written with h.mu held, so
+// this takes the lock itself rather than leaving it to the caller.
+func (h *Hub) subscribed(client *Client, channel string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return client.channels[channel]
+}
+
+// channelAllowed reports whether client may send a message scoped to
+// channel — publishing to it or directing a signaling message into it.
+// A token's room restriction has to hold on every send path, not just
+// ControlSubscribe, so this is the single check shared by all of them.
+func (h *Hub) channelAllowed(client *Client, channel string) bool {
+	return client.roomAllowed(channel) && (channel == BroadcastChannel || h.subscribed(client, channel))
+}
+
+// safeSend writes msg to client.send on behalf of a goroutine other than
+// Hub.Run (readPump and handleWebSocket both reply to a client directly
+// instead of round-tripping through broadcast/direct). Holding h.mu.RLock
+// here serializes against the unregister case's h.mu.Lock, so a send can
+// never race a close: either this sees client.closed and drops the message,
+// or it lands before the hub has any chance to close send.
+func (h *Hub) safeSend(client *Client, msg Message) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if client.closed {
+		return
+	}
+	select {
+	case client.send <- msg:
+	default:
+		messagesDroppedTotal.Inc()
+		go func(c *Client) { h.unregister <- c }(client)
 	}
 }
 
@@ -52,31 +284,77 @@ func (h *Hub) Run() {
 		select {
 		case client := <-h.register:
 			h.mu.Lock()
-			h.clients[client] = true
+			h.subscribe(client, BroadcastChannel)
 			h.mu.Unlock()
-			log.Printf("Client registered: %s in room %s", client.username, client.room)
+			clientsConnected.WithLabelValues(roomLabel(BroadcastChannel)).Inc()
+			logger.Info("client registered", "client_id", client.id, "remote_addr", client.remoteAddr)
 
 		case client := <-h.unregister:
 			h.mu.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-				log.Printf("Client unregistered: %s", client.username)
+			if client.closed {
+				// readPump's deferred unregister and a full-send-buffer kick
+				// from the broadcast/direct cases below can all fire for the
+				// same client; only the first should unsubscribe and close.
+				h.mu.Unlock()
+				continue
+			}
+			client.closed = true
+			for channel := range client.channels {
+				h.unsubscribe(client, channel)
+				clientsConnected.WithLabelValues(roomLabel(channel)).Dec()
+				if client.sfu != nil {
+					client.sfu.Leave(channel, client.username)
+				}
 			}
+			close(client.send)
 			h.mu.Unlock()
+			logger.Info("client unregistered", "client_id", client.id, "remote_addr", client.remoteAddr)
 
 		case message := <-h.broadcast:
+			_, span := tracer.Start(context.Background(), "hub.broadcast")
+			fanoutStart := time.Now()
+
+			h.mu.Lock()
+			h.nextID++
+			message.ID = h.nextID
+			h.recordHistory(message.Chan, message)
+			h.mu.Unlock()
+			messagesBroadcastTotal.Inc()
+
+			if h.store != nil {
+				select {
+				case h.persist <- message:
+				default:
+					logger.Warn("persist buffer full, dropping message", "room", message.Chan)
+				}
+			}
+
+			h.mu.RLock()
+			for client := range h.subscribers[message.Chan] {
+				select {
+				case client.send <- message:
+				default:
+					// Client's send channel is full, remove them
+					messagesDroppedTotal.Inc()
+					go func(c *Client) { h.unregister <- c }(client)
+				}
+			}
+			h.mu.RUnlock()
+
+			broadcastFanoutSeconds.Observe(time.Since(fanoutStart).Seconds())
+			span.End()
+
+		case message := <-h.direct:
 			h.mu.RLock()
-			for client := range h.clients {
-				// Only send to clients in the same room
-				if client.room == message.Room {
-					select {
-					case client.send <- message:
-					default:
-						// Client's send channel is full, remove them
-						close(client.send)
-						delete(h.clients, client)
-					}
+			for client := range h.subscribers[message.Chan] {
+				if client.username != message.Target {
+					continue
+				}
+				select {
+				case client.send <- message:
+				default:
+					messagesDroppedTotal.Inc()
+					go func(c *Client) { h.unregister <- c }(client)
 				}
 			}
 			h.mu.RUnlock()
@@ -84,15 +362,19 @@ func (h *Hub) Run() {
 	}
 }
 
-// WebSocket upgrader configuration
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		// Allow all origins for development
-		// In production, check r.Header.Get("Origin") against allowed domains
-		return true
-	},
+// newUpgrader builds a websocket.Upgrader from cfg, validating Origin
+// against the configured allowlist instead of always accepting it.
+func newUpgrader(cfg Config) websocket.Upgrader {
+	return websocket.Upgrader{
+		HandshakeTimeout:  cfg.HandshakeTimeout,
+		ReadBufferSize:    cfg.ReadBufferSize,
+		WriteBufferSize:   cfg.WriteBufferSize,
+		EnableCompression: cfg.EnableCompression,
+		Subprotocols:      cfg.Subprotocols,
+		CheckOrigin: func(r *http.Request) bool {
+			return cfg.originAllowed(r.Header.Get("Origin"))
+		},
+	}
 }
 
 // readPump handles incoming messages from the client
@@ -103,9 +385,10 @@ func (c *Client) readPump(hub *Hub) {
 	}()
 
 	// Configure read deadline and pong handler
-	c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	c.conn.SetReadLimit(c.cfg.MaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(c.cfg.PongWait))
 	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		c.conn.SetReadDeadline(time.Now().Add(c.cfg.PongWait))
 		return nil
 	})
 
@@ -113,30 +396,109 @@ func (c *Client) readPump(hub *Hub) {
 		_, messageData, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
+				readPumpErrorsTotal.Inc()
+				logger.Error("read pump error", "client_id", c.id, "remote_addr", c.remoteAddr, "error", err)
 			}
 			break
 		}
+		messageSizeBytes.Observe(float64(len(messageData)))
 
 		var msg Message
 		if err := json.Unmarshal(messageData, &msg); err != nil {
-			log.Printf("Error unmarshaling message: %v", err)
+			logger.Warn("failed to unmarshal message", "client_id", c.id, "error", err)
 			continue
 		}
 
-		// Set metadata
 		msg.Username = c.username
-		msg.Room = c.room
+		msg.UserID = c.userID
 		msg.Timestamp = time.Now()
 
-		// Broadcast to hub
-		hub.broadcast <- msg
+		switch {
+		case msg.Type == ControlSubscribe && !c.roomAllowed(msg.Chan):
+			logger.Warn("rejected subscribe", "client_id", c.id, "room", msg.Chan)
+		case msg.Type == ControlSubscribe:
+			hub.mu.Lock()
+			alreadySubscribed := c.channels[msg.Chan]
+			hub.subscribe(c, msg.Chan)
+			hub.mu.Unlock()
+			if !alreadySubscribed {
+				clientsConnected.WithLabelValues(roomLabel(msg.Chan)).Inc()
+			}
+			// Replay buffered history before live traffic, so late joiners and
+			// reconnecting clients (via Since/Cursor) get immediate context
+			// instead of only seeing messages sent from this point on.
+			for _, backlogMsg := range hub.historySince(msg.Chan, msg.Since, msg.Cursor) {
+				hub.safeSend(c, backlogMsg)
+			}
+			// Hand the new subscriber the current roster so it knows who to
+			// send WebRTC offers to, then let existing peers know it arrived.
+			hub.safeSend(c, Message{Type: SignalPeerJoin, Chan: msg.Chan, Content: strings.Join(hub.Peers(msg.Chan), ","), Timestamp: time.Now()})
+			hub.broadcast <- Message{Type: SignalPeerJoin, Username: c.username, Chan: msg.Chan, Timestamp: time.Now()}
+		case msg.Type == ControlUnsubscribe:
+			hub.mu.Lock()
+			wasSubscribed := c.channels[msg.Chan]
+			hub.unsubscribe(c, msg.Chan)
+			hub.mu.Unlock()
+			if wasSubscribed {
+				clientsConnected.WithLabelValues(roomLabel(msg.Chan)).Dec()
+			}
+			hub.broadcast <- Message{Type: SignalPeerLeave, Username: c.username, Chan: msg.Chan, Timestamp: time.Now()}
+		case msg.Type == SignalSDPOffer && msg.Target == SFUTarget && c.sfu != nil && hub.channelAllowed(c, msg.Chan):
+			// Offer addressed to the server itself: terminate it at the SFU
+			// relay and answer directly, rather than forwarding peer-to-peer.
+			// onICECandidate trickles the relay's own candidates back to the
+			// client the same way a mesh peer's candidates would arrive.
+			answerSDP, err := c.sfu.HandleOffer(msg.Chan, c.username, msg.Content, func(candidate webrtc.ICECandidateInit) {
+				payload, err := json.Marshal(candidate)
+				if err != nil {
+					logger.Error("sfu failed to marshal ice candidate", "client_id", c.id, "error", err)
+					return
+				}
+				hub.safeSend(c, Message{Type: SignalICECandidate, Chan: msg.Chan, Target: c.username, Content: string(payload), Timestamp: time.Now()})
+			})
+			if err != nil {
+				logger.Error("sfu failed to handle offer", "client_id", c.id, "room", msg.Chan, "error", err)
+				continue
+			}
+			hub.safeSend(c, Message{Type: SignalSDPAnswer, Chan: msg.Chan, Target: c.username, Content: answerSDP, Timestamp: time.Now()})
+		case msg.Type == SignalICECandidate && msg.Target == SFUTarget && c.sfu != nil && hub.channelAllowed(c, msg.Chan):
+			// Trickled ICE candidate for the relay connection, not a peer —
+			// without this case it fell through to isSignaling below and
+			// hub.direct, which only delivers to a client named "__sfu__"
+			// (there is none), so these were silently dropped.
+			var candidate webrtc.ICECandidateInit
+			if err := json.Unmarshal([]byte(msg.Content), &candidate); err != nil {
+				logger.Warn("failed to unmarshal ice candidate for sfu", "client_id", c.id, "error", err)
+				continue
+			}
+			if err := c.sfu.AddICECandidate(msg.Chan, c.username, candidate); err != nil {
+				logger.Error("sfu failed to add ice candidate", "client_id", c.id, "room", msg.Chan, "error", err)
+			}
+		case isSignaling(msg.Type) && !hub.channelAllowed(c, msg.Chan):
+			// hub.direct only filters by recipient (message.Target); it never
+			// checks whether the sender had any right to be in msg.Chan. Without
+			// this, a token restricted to "room-a" could reach into "room-b" by
+			// directing an sdp-offer/ice-candidate there instead of publishing.
+			logger.Warn("rejected signaling message", "client_id", c.id, "room", msg.Chan)
+		case isSignaling(msg.Type):
+			// SDP/ICE/peer-join/peer-leave are point-to-point and must not
+			// fan out to the whole channel.
+			hub.direct <- msg
+		case !hub.channelAllowed(c, msg.Chan):
+			// Same room scoping ControlSubscribe enforces on the read side:
+			// without it, a token restricted to "room-a" could still publish
+			// into "room-b" by setting msg.Chan on an outbound message.
+			logger.Warn("rejected publish", "client_id", c.id, "room", msg.Chan)
+		default:
+			// "publish" and plain chat messages both route through the channel fan-out
+			hub.broadcast <- msg
+		}
 	}
 }
 
 // writePump handles outgoing messages to the client
 func (c *Client) writePump() {
-	ticker := time.NewTicker(54 * time.Second)
+	ticker := time.NewTicker(c.cfg.PingInterval)
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
@@ -145,7 +507,7 @@ func (c *Client) writePump() {
 	for {
 		select {
 		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			c.conn.SetWriteDeadline(time.Now().Add(c.cfg.WriteWait))
 			if !ok {
 				// Hub closed the channel
 				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
@@ -154,13 +516,14 @@ func (c *Client) writePump() {
 
 			// Send message as JSON
 			if err := c.conn.WriteJSON(message); err != nil {
-				log.Printf("Error writing message: %v", err)
+				writePumpErrorsTotal.Inc()
+				logger.Error("write pump error", "client_id", c.id, "remote_addr", c.remoteAddr, "error", err)
 				return
 			}
 
 		case <-ticker.C:
 			// Send ping to keep connection alive
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			c.conn.SetWriteDeadline(time.Now().Add(c.cfg.WriteWait))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
@@ -168,43 +531,79 @@ func (c *Client) writePump() {
 	}
 }
 
-// handleWebSocket handles WebSocket connection requests
-func handleWebSocket(hub *Hub, w http.ResponseWriter, r *http.Request) {
-	// Get username and room from query parameters
-	username := r.URL.Query().Get("username")
-	room := r.URL.Query().Get("room")
+// parseResumeParams reads the since=<messageID> and cursor=<RFC3339
+// timestamp> query parameters used to resume a backlog replay. Either, both,
+// or neither may be set; unset or unparsable values are treated as zero.
+func parseResumeParams(r *http.Request) (since uint64, cursor time.Time) {
+	if v := r.URL.Query().Get("since"); v != "" {
+		since, _ = strconv.ParseUint(v, 10, 64)
+	}
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		cursor, _ = time.Parse(time.RFC3339, v)
+	}
+	return since, cursor
+}
 
+// handleWebSocket handles WebSocket connection requests
+func handleWebSocket(hub *Hub, cfg Config, sfu *SFU, w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "ws.upgrade")
+	defer span.End()
+
+	// Verify the session token before ever touching the WebSocket handshake.
+	// Username, userID and (if present) the caller's room allowlist are all
+	// derived from the claims, not trusted from client-supplied params.
+	claims, err := authenticate(cfg, r.WithContext(ctx))
+	if err != nil {
+		http.Error(w, "invalid or expired token", http.StatusUnauthorized)
+		return
+	}
+	username := claims.Subject
 	if username == "" {
 		username = "Anonymous"
 	}
-	if room == "" {
-		room = "general"
-	}
 
-	// Upgrade HTTP connection to WebSocket
+	// Upgrade HTTP connection to WebSocket. CheckOrigin on the upgrader
+	// rejects the handshake before this point if Origin isn't allowlisted.
+	upgrader := newUpgrader(cfg)
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
+		upgradeFailuresTotal.Inc()
+		logger.Error("websocket upgrade error", "remote_addr", r.RemoteAddr, "error", err)
 		return
 	}
 
 	// Create new client
 	client := &Client{
-		conn:     conn,
-		username: username,
-		room:     room,
-		send:     make(chan Message, 256),
+		id:           newClientID(),
+		remoteAddr:   r.RemoteAddr,
+		conn:         conn,
+		username:     username,
+		userID:       claims.UserID,
+		allowedRooms: claims.Rooms,
+		channels:     make(map[string]bool),
+		send:         make(chan Message, 256),
+		cfg:          cfg,
+		sfu:          sfu,
 	}
 
-	// Register client with hub
+	// Register client with hub (subscribes it to the broadcast channel)
 	hub.register <- client
 
-	// Send join notification
+	// A reconnecting client can pass since=<messageID> or cursor=<RFC3339
+	// timestamp> to resume the broadcast channel's backlog from where it
+	// left off instead of replaying everything the ring buffer has.
+	since, cursor := parseResumeParams(r)
+	for _, backlogMsg := range hub.historySince(BroadcastChannel, since, cursor) {
+		hub.safeSend(client, backlogMsg)
+	}
+
+	// Send join notification on the broadcast channel
 	joinMsg := Message{
 		Type:      "join",
 		Username:  username,
-		Content:   username + " joined the room",
-		Room:      room,
+		UserID:    claims.UserID,
+		Content:   username + " joined",
+		Chan:      BroadcastChannel,
 		Timestamp: time.Now(),
 	}
 	hub.broadcast <- joinMsg
@@ -240,23 +639,58 @@ func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 }
 
 func main() {
-	// Create and start hub
-	hub := NewHub()
+	sfuEnabled := flag.Bool("sfu", false, "relay WebRTC media server-side instead of leaving peers to mesh directly")
+	flag.Parse()
+
+	cfg := LoadConfig()
+
+	store, err := newStore(cfg)
+	if err != nil {
+		log.Fatal("store init error:", err)
+	}
+
+	authenticator, err := newAuthenticator(cfg)
+	if err != nil {
+		log.Fatal("auth init error:", err)
+	}
+
+	// Create and start hub; broadcasts are persisted asynchronously so a slow
+	// store can never block the live fan-out.
+	hub := NewHubWithStore(DefaultHistorySize, store)
 	go hub.Run()
+	go hub.runPersistence()
+
+	// Signaling (SDP/ICE exchange) always flows peer-to-peer through the hub's
+	// direct channel. The SFU, when enabled, additionally terminates offers
+	// addressed to SFUTarget and relays the resulting media server-side
+	// instead of each peer uploading to every other peer (mesh).
+	var sfu *SFU
+	if *sfuEnabled {
+		var err error
+		sfu, err = NewSFU()
+		if err != nil {
+			log.Fatal("SFU init error:", err)
+		}
+		logger.Info("SFU relay enabled")
+	}
 
 	// Setup routes
 	http.HandleFunc("/ws", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		handleWebSocket(hub, w, r)
+		handleWebSocket(hub, cfg, sfu, w, r)
 	}))
 	http.HandleFunc("/health", corsMiddleware(handleHealth))
+	http.HandleFunc("/login", corsMiddleware(handleLogin(cfg, authenticator)))
+	http.HandleFunc("GET /rooms/{room}/history", corsMiddleware(authMiddleware(cfg, handleRoomHistory(store))))
+	http.HandleFunc("GET /rooms", corsMiddleware(authMiddleware(cfg, handleListRooms(hub))))
+	http.HandleFunc("/metrics", handleMetrics())
 
 	// Start server
 	port := ":8080"
-	log.Printf("Chat server starting on %s", port)
-	log.Printf("WebSocket endpoint: ws://localhost%s/ws", port)
-	log.Printf("Health check: http://localhost%s/health", port)
+	logger.Info("chat server starting", "port", port)
+	logger.Info("websocket endpoint", "url", "ws://localhost"+port+"/ws")
+	logger.Info("health check", "url", "http://localhost"+port+"/health")
 
 	if err := http.ListenAndServe(port, nil); err != nil {
 		log.Fatal("Server error:", err)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus metrics for the hub and connection lifecycle. All are
+// registered against the default registry so a plain promhttp.Handler on
+// /metrics picks them up with no extra wiring.
+var (
+	clientsConnected = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "chat_clients_connected",
+		Help: "Number of WebSocket clients currently subscribed, by channel.",
+	}, []string{"room"})
+
+	messagesBroadcastTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_messages_broadcast_total",
+		Help: "Total number of messages fanned out by the hub.",
+	})
+
+	messagesDroppedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_messages_dropped_total",
+		Help: "Total number of messages dropped because a client's send buffer was full.",
+	})
+
+	upgradeFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_upgrade_failures_total",
+		Help: "Total number of WebSocket upgrade attempts that failed.",
+	})
+
+	readPumpErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_read_pump_errors_total",
+		Help: "Total number of unexpected errors from client read pumps.",
+	})
+
+	writePumpErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_write_pump_errors_total",
+		Help: "Total number of unexpected errors from client write pumps.",
+	})
+
+	messageSizeBytes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chat_message_size_bytes",
+		Help:    "Size in bytes of inbound WebSocket messages.",
+		Buckets: prometheus.ExponentialBuckets(32, 4, 8),
+	})
+
+	broadcastFanoutSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chat_broadcast_fanout_seconds",
+		Help:    "Latency between a message entering broadcast and its channel fan-out completing.",
+		Buckets: prometheus.DefBuckets,
+	})
+)
+
+// maxTrackedRooms bounds how many distinct "room" label values
+// clientsConnected will report. Room names come straight from
+// client-supplied subscribe messages, and Prometheus label sets are never
+// garbage collected, so without a cap any authenticated client could balloon
+// the exporter's memory by subscribing to an endless stream of new channel
+// names. Rooms beyond the cap are folded into the "other" bucket instead.
+const maxTrackedRooms = 1000
+
+var (
+	roomLabelsMu sync.Mutex
+	roomLabels   = make(map[string]bool)
+)
+
+// roomLabel returns the "room" label value clientsConnected should use for
+// room: room itself if it is already tracked or fewer than maxTrackedRooms
+// distinct rooms have been seen so far, "other" for any new room once that
+// cap is reached.
+func roomLabel(room string) string {
+	roomLabelsMu.Lock()
+	defer roomLabelsMu.Unlock()
+
+	if roomLabels[room] {
+		return room
+	}
+	if len(roomLabels) >= maxTrackedRooms {
+		return "other"
+	}
+	roomLabels[room] = true
+	return room
+}
+
+// handleMetrics serves /metrics for Prometheus to scrape.
+func handleMetrics() http.HandlerFunc {
+	return promhttp.Handler().ServeHTTP
+}
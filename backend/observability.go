@@ -0,0 +1,20 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+
+	"go.opentelemetry.io/otel"
+)
+
+// logger is the structured logger used throughout the server in place of
+// the package-level log.Printf calls it replaced. Every log site attaches
+// whichever of client_id, room, remote_addr are relevant so log lines can be
+// correlated with a specific connection.
+var logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+// tracer emits spans around the upgrade handshake and each broadcast fan-out.
+// No exporter is configured here; wiring a real one (OTLP, stdout, etc.) is
+// an operator concern left to main's deployment, and otel.Tracer degrades to
+// a no-op when none is registered.
+var tracer = otel.Tracer("chat-app")
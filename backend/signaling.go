@@ -0,0 +1,153 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// SFU optionally relays media server-side instead of leaving peers to mesh
+// directly. It is only wired up when the server is started with --sfu;
+// otherwise signaling messages are forwarded mesh-style by the Hub and the
+// server never touches an RTP packet.
+type SFU struct {
+	api   *webrtc.API
+	mu    sync.Mutex
+	rooms map[string]map[string]*webrtc.PeerConnection // room -> username -> connection
+}
+
+// NewSFU creates an SFU relay using default ICE/codec settings.
+func NewSFU() (*SFU, error) {
+	return &SFU{
+		api:   webrtc.NewAPI(),
+		rooms: make(map[string]map[string]*webrtc.PeerConnection),
+	}, nil
+}
+
+// Join creates (or replaces) the relay's PeerConnection for username in room
+// and returns it so the caller can feed it the client's SDP offer.
+// onICECandidate, if non-nil, is invoked with each locally gathered ICE
+// candidate so the caller can trickle it back to the client; without this,
+// the relay's candidates are never sent and connectivity across a NAT that
+// the default host/srflx candidate in the SDP answer can't traverse.
+func (s *SFU) Join(room, username string, onICECandidate func(webrtc.ICECandidateInit)) (*webrtc.PeerConnection, error) {
+	pc, err := s.api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if s.rooms[room] == nil {
+		s.rooms[room] = make(map[string]*webrtc.PeerConnection)
+	}
+	if existing, ok := s.rooms[room][username]; ok {
+		existing.Close()
+	}
+	s.rooms[room][username] = pc
+	s.mu.Unlock()
+
+	pc.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		s.relay(room, username, track)
+	})
+
+	if onICECandidate != nil {
+		pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+			if candidate == nil {
+				// nil signals end-of-candidates; trickle ICE has nothing
+				// further to send.
+				return
+			}
+			onICECandidate(candidate.ToJSON())
+		})
+	}
+
+	return pc, nil
+}
+
+// HandleOffer applies a client's SDP offer to its relay PeerConnection and
+// returns the SDP answer to send back. It always calls Join first, so any
+// existing relay connection for username in room is replaced.
+// See Join for onICECandidate.
+func (s *SFU) HandleOffer(room, username, offerSDP string, onICECandidate func(webrtc.ICECandidateInit)) (string, error) {
+	pc, err := s.Join(room, username, onICECandidate)
+	if err != nil {
+		return "", err
+	}
+
+	offer := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offerSDP}
+	if err := pc.SetRemoteDescription(offer); err != nil {
+		return "", err
+	}
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return "", err
+	}
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return "", err
+	}
+
+	return answer.SDP, nil
+}
+
+// AddICECandidate applies a trickled ICE candidate from username's client to
+// its relay PeerConnection in room. It is a no-op if the peer hasn't sent an
+// offer yet (no PeerConnection to apply it to).
+func (s *SFU) AddICECandidate(room, username string, candidate webrtc.ICECandidateInit) error {
+	s.mu.Lock()
+	pc, ok := s.rooms[room][username]
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return pc.AddICECandidate(candidate)
+}
+
+// Leave tears down the relay connection for username in room, if any.
+func (s *SFU) Leave(room, username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if peers, ok := s.rooms[room]; ok {
+		if pc, ok := peers[username]; ok {
+			pc.Close()
+			delete(peers, username)
+		}
+		if len(peers) == 0 {
+			delete(s.rooms, room)
+		}
+	}
+}
+
+// relay forwards an inbound track from one peer to every other peer
+// connection in the room, giving the mesh O(1) uplinks per participant
+// instead of O(n).
+func (s *SFU) relay(room, from string, track *webrtc.TrackRemote) {
+	local, err := webrtc.NewTrackLocalStaticRTP(track.Codec().RTPCodecCapability, track.ID(), track.StreamID())
+	if err != nil {
+		logger.Error("sfu failed to create local track for relay", "room", room, "error", err)
+		return
+	}
+
+	s.mu.Lock()
+	for username, pc := range s.rooms[room] {
+		if username == from {
+			continue
+		}
+		if _, err := pc.AddTrack(local); err != nil {
+			logger.Error("sfu failed to add relayed track", "room", room, "username", username, "error", err)
+		}
+	}
+	s.mu.Unlock()
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := track.Read(buf)
+		if err != nil {
+			return
+		}
+		if _, err := local.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
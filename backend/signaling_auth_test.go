@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+// TestHubChannelAllowedGatesSignaling guards against a regression where
+// signaling messages (sdp-offer/sdp-answer/ice-candidate/peer-join/peer-leave)
+// were forwarded to hub.direct with no check that the sender had any right to
+// be in msg.Chan — hub.direct only filters by recipient, so a token scoped to
+// "room-a" could otherwise reach into "room-b" by directing a signaling
+// message there instead of publishing.
+func TestHubChannelAllowedGatesSignaling(t *testing.T) {
+	h := NewHub()
+
+	scoped := newTestClient("scoped")
+	scoped.allowedRooms = []string{"room-a"}
+	h.mu.Lock()
+	h.subscribe(scoped, "room-a")
+	h.mu.Unlock()
+
+	tests := []struct {
+		name    string
+		client  *Client
+		channel string
+		want    bool
+	}{
+		{"allowed and subscribed room", scoped, "room-a", true},
+		{"outside the token's rooms and not subscribed", scoped, "room-b", false},
+		{"broadcast channel always allowed", scoped, BroadcastChannel, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := h.channelAllowed(tt.client, tt.channel); got != tt.want {
+				t.Errorf("channelAllowed(%q) = %v, want %v", tt.channel, got, tt.want)
+			}
+		})
+	}
+
+	// A token with no room restriction is still gated by subscription
+	// membership outside the broadcast channel.
+	unrestricted := newTestClient("unrestricted")
+	if h.channelAllowed(unrestricted, "room-a") {
+		t.Error("channelAllowed(room-a) for an unsubscribed client = true, want false")
+	}
+	if !h.channelAllowed(unrestricted, BroadcastChannel) {
+		t.Error("channelAllowed(BroadcastChannel) = false, want true")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store persists chat messages so history survives process restarts.
+// Append must be safe to call concurrently; History returns messages for
+// room older than before, most recent first, capped at limit.
+type Store interface {
+	Append(ctx context.Context, msg Message) error
+	History(ctx context.Context, room string, before time.Time, limit int) ([]Message, error)
+}
+
+// newStore builds the Store configured by cfg.StoreDriver/cfg.StoreDSN.
+func newStore(cfg Config) (Store, error) {
+	switch cfg.StoreDriver {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "sqlite":
+		return NewSQLiteStore(cfg.StoreDSN)
+	case "postgres":
+		return NewPostgresStore(cfg.StoreDSN)
+	default:
+		return nil, fmt.Errorf("unknown store driver %q", cfg.StoreDriver)
+	}
+}
+
+// MemoryStore is a non-durable Store backed by a slice per room. It exists
+// as the zero-configuration default and as a Store implementation to test
+// against; restarting the process loses everything it holds.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	byRoom map[string][]Message
+}
+
+// NewMemoryStore creates an empty in-memory Store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{byRoom: make(map[string][]Message)}
+}
+
+// Append implements Store.
+func (s *MemoryStore) Append(_ context.Context, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byRoom[msg.Chan] = append(s.byRoom[msg.Chan], msg)
+	return nil
+}
+
+// History implements Store.
+func (s *MemoryStore) History(_ context.Context, room string, before time.Time, limit int) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matched []Message
+	for _, msg := range s.byRoom[room] {
+		if !before.IsZero() && !msg.Timestamp.Before(before) {
+			continue
+		}
+		matched = append(matched, msg)
+	}
+
+	sort.Slice(matched, func(i, j int) bool { return matched[i].Timestamp.After(matched[j].Timestamp) })
+	if limit > 0 && len(matched) > limit {
+		matched = matched[:limit]
+	}
+	return matched, nil
+}